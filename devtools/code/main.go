@@ -23,14 +23,29 @@
 //
 //	# Use a specific review mode (loads prompt from prompts/ folder)
 //	dagger -m ./devtools/code call review-diff --source=. --base=main --head=HEAD --mode=security
+//
+//	# Review hermetically, with no host dependency on Ollama
+//	dagger -m ./devtools/code call serve-ollama up &
+//	dagger -m ./devtools/code call review-diff --source=. --base=main --head=HEAD \
+//	  --ollama=tcp://localhost:11434
+//
+//	# Review a large diff file-by-file instead of truncating it
+//	dagger -m ./devtools/code call review-diff-chunked --source=. --base=main --head=HEAD
+//
+//	# Debug a prompt template's rendering without running a review
+//	dagger -m ./devtools/code call render-prompt --mode=security --vars=base=main,head=HEAD
 
 package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"dagger/code/internal/dagger"
 )
@@ -39,17 +54,27 @@ const (
 	// Base image for reviewer containers
 	alpineImage = "alpine:3.19"
 
+	// Base image for the in-cluster Ollama service
+	ollamaImage = "ollama/ollama:latest"
+
 	// Default model - gemma3:4b is a good balance of speed and quality
 	defaultModel = "gemma3:4b"
 
 	// Ollama host when running on the user's machine
 	defaultOllamaHost = "host.docker.internal:11434"
 
+	// Host:port used to reach a service started by ServeOllama, once bound
+	// into a container via WithServiceBinding("ollama", svc)
+	boundOllamaHost = "ollama:11434"
+
 	// Paths relative to module root
 	configDir  = "config"
 	promptsDir = "prompts"
 )
 
+// defaultOllamaModels are pulled by ServeOllama when no models are requested.
+var defaultOllamaModels = []string{defaultModel}
+
 type Code struct{}
 
 // =============================================================================
@@ -57,13 +82,149 @@ type Code struct{}
 // =============================================================================
 
 // reviewerContainer creates a container with git and curl for interacting with Ollama.
-func (m *Code) reviewerContainer(source *dagger.Directory, moduleDir *dagger.Directory) *dagger.Container {
-	return dag.Container().
+// When ollama is non-nil, it is bound into the container as the "ollama" service
+// so callers can reach it hermetically at boundOllamaHost instead of the host network.
+func (m *Code) reviewerContainer(source *dagger.Directory, moduleDir *dagger.Directory, ollama *dagger.Service) *dagger.Container {
+	ctr := dag.Container().
 		From(alpineImage).
 		WithExec([]string{"apk", "add", "--no-cache", "git", "curl", "jq"}).
 		WithDirectory("/repo", source).
 		WithDirectory("/module", moduleDir).
 		WithWorkdir("/repo")
+	return withOllama(ctr, ollama)
+}
+
+// withOllama binds a running Ollama service onto ctr, if one was supplied.
+func withOllama(ctr *dagger.Container, ollama *dagger.Service) *dagger.Container {
+	if ollama == nil {
+		return ctr
+	}
+	return ctr.WithServiceBinding("ollama", ollama)
+}
+
+// resolveOllamaHost picks the host:port to reach Ollama at: the bound service
+// takes priority over the explicit host flag, which keeps the common case
+// (an ollama service passed in) hermetic without callers needing to also
+// clear ollamaHost.
+func resolveOllamaHost(ollama *dagger.Service, ollamaHost string) string {
+	if ollama != nil {
+		return boundOllamaHost
+	}
+	if ollamaHost == "" {
+		return defaultOllamaHost
+	}
+	return ollamaHost
+}
+
+// =============================================================================
+// Ollama Service
+// =============================================================================
+
+// ollamaPullScript starts the Ollama server in the background, waits for it
+// to come up, then pulls each requested model before handing control back to
+// the server process. ServeOllama runs it as the service's command; it never
+// exits on its own, so CommitModelImage uses ollamaPullAndExitScript instead.
+func ollamaPullScript(models []string) string {
+	return fmt.Sprintf(`
+set -e
+ollama serve &
+OLLAMA_PID=$!
+
+until ollama list >/dev/null 2>&1; do
+    sleep 1
+done
+
+for model in %s; do
+    ollama pull "$model"
+done
+
+wait $OLLAMA_PID
+`, strings.Join(models, " "))
+}
+
+// ollamaPullAndExitScript is like ollamaPullScript, but stops the backgrounded
+// server once the pulls finish instead of waiting on it forever. Used by
+// CommitModelImage, whose WithExec must actually complete so the resulting
+// container can be published.
+func ollamaPullAndExitScript(models []string) string {
+	return fmt.Sprintf(`
+set -e
+ollama serve &
+OLLAMA_PID=$!
+
+until ollama list >/dev/null 2>&1; do
+    sleep 1
+done
+
+for model in %s; do
+    ollama pull "$model"
+done
+
+kill $OLLAMA_PID
+wait $OLLAMA_PID 2>/dev/null || true
+`, strings.Join(models, " "))
+}
+
+// ollamaContainer builds an Ollama container with the requested models
+// pulled and, if gpu is set, the host's GPUs bound in.
+func ollamaContainer(models []string, gpu bool) *dagger.Container {
+	if len(models) == 0 {
+		models = defaultOllamaModels
+	}
+
+	ctr := dag.Container().From(ollamaImage)
+	if gpu {
+		ctr = ctr.WithGPU([]string{"all"})
+	}
+
+	return ctr.
+		WithExposedPort(11434).
+		WithExec([]string{"sh", "-c", ollamaPullScript(models)},
+			dagger.ContainerWithExecOpts{InsecureRootCapabilities: false})
+}
+
+// ServeOllama starts an Ollama server as a background service, pulling the
+// given models on startup so ReviewDiff and friends work with no host
+// dependency. Bind it into a reviewer call via the ollama parameter.
+func (m *Code) ServeOllama(
+	// +optional
+	// Models to pull on startup, e.g. []string{"gemma3:4b", "qwen2.5-coder:7b"}
+	models []string,
+	// +optional
+	// +default=false
+	// Bind the host's GPUs into the container, if any are available
+	gpu bool,
+) *dagger.Service {
+	return ollamaContainer(models, gpu).AsService()
+}
+
+// CommitModelImage pulls the given models into an Ollama container and
+// returns the container so it can be published as a cached image. Starting
+// ServeOllama from that image skips the model pull on every subsequent run,
+// the same speedup used for model-heavy containers elsewhere in this repo.
+// Unlike ServeOllama, its exec must terminate rather than run forever, so it
+// uses ollamaPullAndExitScript instead of ollamaContainer's run-forever script.
+func (m *Code) CommitModelImage(
+	// +optional
+	// Models to bake into the image
+	models []string,
+	// +optional
+	// +default=false
+	gpu bool,
+) *dagger.Container {
+	if len(models) == 0 {
+		models = defaultOllamaModels
+	}
+
+	ctr := dag.Container().From(ollamaImage)
+	if gpu {
+		ctr = ctr.WithGPU([]string{"all"})
+	}
+
+	return ctr.
+		WithExposedPort(11434).
+		WithExec([]string{"sh", "-c", ollamaPullAndExitScript(models)},
+			dagger.ContainerWithExecOpts{InsecureRootCapabilities: false})
 }
 
 // =============================================================================
@@ -91,14 +252,109 @@ func loadSkipPatterns(moduleDir *dagger.Directory, ctx context.Context) ([]strin
 	return patterns, nil
 }
 
-// loadPrompt reads a prompt from prompts/<mode>.md
-func loadPrompt(moduleDir *dagger.Directory, mode string, ctx context.Context) (string, error) {
+// promptFrontMatter holds the per-mode metadata a prompt file can declare in
+// a leading "---" front-matter block: recommended model/temperature, and
+// the output format ReviewDiff should use ("text" or "json").
+type promptFrontMatter struct {
+	Model       string
+	Temperature string
+	Format      string
+}
+
+// parseFrontMatter splits a leading "---\n...\n---\n" front-matter block off
+// a prompt file, returning the parsed metadata (zero value if absent) and
+// the remaining template body. Front matter is a flat set of "key: value"
+// lines - enough for this repo's needs without a YAML dependency.
+func parseFrontMatter(content string) (promptFrontMatter, string) {
+	var fm promptFrontMatter
+	if !strings.HasPrefix(content, "---\n") {
+		return fm, content
+	}
+
+	rest := content[len("---\n"):]
+	end := strings.Index(rest, "\n---\n")
+	if end == -1 {
+		return fm, content
+	}
+
+	for _, line := range strings.Split(rest[:end], "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "model":
+			fm.Model = strings.TrimSpace(value)
+		case "temperature":
+			fm.Temperature = strings.TrimSpace(value)
+		case "format":
+			fm.Format = strings.TrimSpace(value)
+		}
+	}
+
+	return fm, rest[end+len("\n---\n"):]
+}
+
+// loadPrompt reads prompts/review-<mode>.md and splits off its front matter,
+// returning the remaining template body.
+func loadPrompt(moduleDir *dagger.Directory, mode string, ctx context.Context) (string, promptFrontMatter, error) {
 	promptFile := moduleDir.File(filepath.Join(promptsDir, fmt.Sprintf("review-%s.md", mode)))
 	content, err := promptFile.Contents(ctx)
 	if err != nil {
-		return "", fmt.Errorf("prompt mode '%s' not found (looking for prompts/review-%s.md)", mode, mode)
+		return "", promptFrontMatter{}, fmt.Errorf("prompt mode '%s' not found (looking for prompts/review-%s.md)", mode, mode)
+	}
+	fm, body := parseFrontMatter(content)
+	return body, fm, nil
+}
+
+// promptVars is the set of template variables available to prompt files.
+type promptVars struct {
+	Repo         string
+	Base         string
+	Head         string
+	ChangedFiles string
+	Language     string
+}
+
+// renderPrompt executes a prompt template against vars, resolving
+// {{include "partials/..."}} against files under promptsDir.
+func renderPrompt(ctx context.Context, moduleDir *dagger.Directory, body string, vars promptVars) (string, error) {
+	funcs := template.FuncMap{
+		"include": func(path string) (string, error) {
+			content, err := moduleDir.File(filepath.Join(promptsDir, path)).Contents(ctx)
+			if err != nil {
+				return "", fmt.Errorf("include %q not found (looking for %s)", path, filepath.Join(promptsDir, path))
+			}
+			return content, nil
+		},
+	}
+
+	tmpl, err := template.New("prompt").Funcs(funcs).Parse(body)
+	if err != nil {
+		return "", fmt.Errorf("invalid prompt template: %w", err)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, vars); err != nil {
+		return "", fmt.Errorf("failed to render prompt template: %w", err)
+	}
+	return out.String(), nil
+}
+
+// changedFilesList runs `git diff --name-only` for the given ref pair and
+// returns the changed paths, one per line, for use as the ChangedFiles
+// template variable.
+func (m *Code) changedFilesList(ctx context.Context, source *dagger.Directory, moduleDir *dagger.Directory, base, head string) (string, error) {
+	skipPatterns, err := loadSkipPatterns(moduleDir, ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to load skip patterns: %w", err)
 	}
-	return content, nil
+	excludes := buildExcludeArgs(skipPatterns)
+	diffCmd := strings.Replace(diffCommand(base, head, excludes), "git diff", "git diff --name-only", 1)
+
+	return m.reviewerContainer(source, moduleDir, nil).
+		WithExec([]string{"sh", "-c", fmt.Sprintf("%s 2>/dev/null || true", diffCmd)}).
+		Stdout(ctx)
 }
 
 // buildExcludeArgs creates git pathspec exclude arguments for skip patterns
@@ -110,6 +366,18 @@ func buildExcludeArgs(patterns []string) string {
 	return strings.Join(excludes, " ")
 }
 
+// diffCommand builds the `git diff` invocation for the given ref pair
+// (base == "--cached" means staged changes), with skip-pattern excludes applied.
+func diffCommand(base, head, excludes string) string {
+	if base == "--cached" {
+		return fmt.Sprintf("git diff --cached -- . %s", excludes)
+	}
+	if head == "" {
+		return fmt.Sprintf("git diff %s -- . %s", base, excludes)
+	}
+	return fmt.Sprintf("git diff %s..%s -- . %s", base, head, excludes)
+}
+
 // =============================================================================
 // Review Functions
 // =============================================================================
@@ -134,6 +402,10 @@ func (m *Code) ReviewDiff(
 	// +optional
 	// +default="host.docker.internal:11434"
 	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
 ) (string, error) {
 	moduleDir := dag.CurrentModule().Source()
 
@@ -141,12 +413,34 @@ func (m *Code) ReviewDiff(
 	if mode == "" {
 		mode = "default"
 	}
-	prompt, err := loadPrompt(moduleDir, mode, ctx)
+	rawPrompt, fm, err := loadPrompt(moduleDir, mode, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	changedFiles, err := m.changedFilesList(ctx, source, moduleDir, base, head)
+	if err != nil {
+		return "", fmt.Errorf("failed to list changed files: %w", err)
+	}
+
+	prompt, err := renderPrompt(ctx, moduleDir, rawPrompt, promptVars{
+		Base:         base,
+		Head:         head,
+		ChangedFiles: strings.TrimSpace(changedFiles),
+	})
 	if err != nil {
 		return "", err
 	}
 
-	return m.analyzeDiff(ctx, source, moduleDir, base, head, model, ollamaHost, prompt+"\n\nGit diff:\n")
+	if model == "" {
+		model = fm.Model
+	}
+
+	if fm.Format == "json" {
+		return m.analyzeDiffJSON(ctx, source, moduleDir, base, head, model, ollamaHost, ollama, fm.Temperature, prompt+"\n\n"+findingsSchemaPrompt+"\n\nGit diff:\n")
+	}
+
+	return m.analyzeDiff(ctx, source, moduleDir, base, head, model, ollamaHost, ollama, fm.Temperature, prompt+"\n\nGit diff:\n")
 }
 
 // ReviewStaged reviews currently staged changes (git diff --cached).
@@ -160,15 +454,33 @@ func (m *Code) ReviewStaged(
 	// +optional
 	// +default="host.docker.internal:11434"
 	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
 ) (string, error) {
 	moduleDir := dag.CurrentModule().Source()
 
-	prompt, err := loadPrompt(moduleDir, "staged", ctx)
+	rawPrompt, fm, err := loadPrompt(moduleDir, "staged", ctx)
+	if err != nil {
+		return "", err
+	}
+
+	changedFiles, err := m.changedFilesList(ctx, source, moduleDir, "--cached", "")
+	if err != nil {
+		return "", fmt.Errorf("failed to list changed files: %w", err)
+	}
+
+	prompt, err := renderPrompt(ctx, moduleDir, rawPrompt, promptVars{ChangedFiles: strings.TrimSpace(changedFiles)})
 	if err != nil {
 		return "", err
 	}
 
-	return m.analyzeDiff(ctx, source, moduleDir, "--cached", "", model, ollamaHost, prompt+"\n\nGit diff (staged):\n")
+	if model == "" {
+		model = fm.Model
+	}
+
+	return m.analyzeDiff(ctx, source, moduleDir, "--cached", "", model, ollamaHost, ollama, fm.Temperature, prompt+"\n\nGit diff (staged):\n")
 }
 
 // ReviewFile reviews a single file for quality, bugs, and improvements.
@@ -184,13 +496,19 @@ func (m *Code) ReviewFile(
 	// +optional
 	// +default="host.docker.internal:11434"
 	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
+	// +optional
+	// Output format: "" for prose, or "json" for structured findings
+	// (see Finding), same as ReviewDiff's front-matter format option
+	format string,
 ) (string, error) {
 	if model == "" {
 		model = defaultModel
 	}
-	if ollamaHost == "" {
-		ollamaHost = defaultOllamaHost
-	}
+	ollamaHost = resolveOllamaHost(ollama, ollamaHost)
 
 	prompt := fmt.Sprintf(`You are reviewing the file: %s
 
@@ -203,6 +521,14 @@ Analyze this file and provide:
 File contents:
 `, filePath)
 
+	formatField := ""
+	jqFilter := `.message.content // .error // "Error: No response from model"`
+	if format == "json" {
+		prompt = fmt.Sprintf("You are reviewing the file: %s\n\n%s\n\nFile contents:\n", filePath, findingsSchemaPrompt)
+		formatField = `\"format\": \"json\",` + "\n        "
+		jqFilter = `.message.content // .error // "[]"`
+	}
+
 	script := fmt.Sprintf(`
 set -e
 FILE_CONTENT=$(cat "%s" 2>/dev/null || echo "ERROR: File not found")
@@ -218,16 +544,16 @@ curl -s "http://%s/api/chat" \
     -H "Content-Type: application/json" \
     -d "{
         \"model\": \"%s\",
-        \"messages\": [{
+        %s\"messages\": [{
             \"role\": \"user\",
             \"content\": ${ESCAPED_PROMPT:1:-1}${ESCAPED_CONTENT:1:-1}
         }],
         \"stream\": false
-    }" | jq -r '.message.content // .error // "Error: No response from model"'
-`, filePath, filePath, prompt, ollamaHost, model)
+    }" | jq -r '%s'
+`, filePath, filePath, prompt, ollamaHost, model, formatField, jqFilter)
 
 	moduleDir := dag.CurrentModule().Source()
-	return m.reviewerContainer(source, moduleDir).
+	return m.reviewerContainer(source, moduleDir, ollama).
 		WithExec([]string{"sh", "-c", script}).
 		Stdout(ctx)
 }
@@ -252,6 +578,10 @@ func (m *Code) SummarizeDiff(
 	// +optional
 	// +default="host.docker.internal:11434"
 	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
 ) (string, error) {
 	moduleDir := dag.CurrentModule().Source()
 
@@ -279,7 +609,7 @@ Avoid jargon. Be specific but concise.
 
 Git diff:
 `
-	return m.analyzeDiff(ctx, source, moduleDir, base, head, model, ollamaHost, prompt)
+	return m.analyzeDiff(ctx, source, moduleDir, base, head, model, ollamaHost, ollama, "", prompt)
 }
 
 // =============================================================================
@@ -303,16 +633,36 @@ func (m *Code) Analyze(
 	// +optional
 	// +default="host.docker.internal:11434"
 	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
+	// +optional
+	// Output format: "" for prose, or "json" for structured findings
+	// (see Finding), same as ReviewDiff's front-matter format option
+	format string,
 ) (string, error) {
 	moduleDir := dag.CurrentModule().Source()
+	if format == "json" {
+		return m.analyzeDiffJSON(ctx, source, moduleDir, base, head, model, ollamaHost, ollama, "", prompt+"\n\n"+findingsSchemaPrompt+"\n\nGit diff:\n")
+	}
 	fullPrompt := prompt + "\n\nGit diff:\n"
-	return m.analyzeDiff(ctx, source, moduleDir, base, head, model, ollamaHost, fullPrompt)
+	return m.analyzeDiff(ctx, source, moduleDir, base, head, model, ollamaHost, ollama, "", fullPrompt)
 }
 
 // =============================================================================
 // Core Analysis Engine
 // =============================================================================
 
+// ollamaOptionsField renders the /api/chat "options" field carrying a
+// recommended temperature (from prompt front matter), or "" when unset.
+func ollamaOptionsField(temperature string) string {
+	if temperature == "" {
+		return ""
+	}
+	return fmt.Sprintf(`\"options\": {\"temperature\": %s},`+"\n        ", temperature)
+}
+
 // analyzeDiff is the core function that gets a diff and sends it to Ollama.
 func (m *Code) analyzeDiff(
 	ctx context.Context,
@@ -322,14 +672,14 @@ func (m *Code) analyzeDiff(
 	head string,
 	model string,
 	ollamaHost string,
+	ollama *dagger.Service,
+	temperature string,
 	prompt string,
 ) (string, error) {
 	if model == "" {
 		model = defaultModel
 	}
-	if ollamaHost == "" {
-		ollamaHost = defaultOllamaHost
-	}
+	ollamaHost = resolveOllamaHost(ollama, ollamaHost)
 
 	// Load skip patterns from config
 	skipPatterns, err := loadSkipPatterns(moduleDir, ctx)
@@ -339,14 +689,7 @@ func (m *Code) analyzeDiff(
 
 	// Build the git diff command with file exclusions
 	excludes := buildExcludeArgs(skipPatterns)
-	var diffCmd string
-	if base == "--cached" {
-		diffCmd = fmt.Sprintf("git diff --cached -- . %s", excludes)
-	} else if head == "" {
-		diffCmd = fmt.Sprintf("git diff %s -- . %s", base, excludes)
-	} else {
-		diffCmd = fmt.Sprintf("git diff %s..%s -- . %s", base, head, excludes)
-	}
+	diffCmd := diffCommand(base, head, excludes)
 
 	// Escape the prompt for JSON
 	escapedPrompt := strings.ReplaceAll(prompt, `"`, `\"`)
@@ -379,9 +722,9 @@ RESPONSE=$(curl -s "http://%s/api/chat" \
     -H "Content-Type: application/json" \
     -d "{
         \"model\": \"%s\",
-        \"messages\": [{
+        %s\"messages\": [{
             \"role\": \"user\",
-            \"content\": \"%s\" 
+            \"content\": \"%s\"
         }, {
             \"role\": \"user\",
             \"content\": ${ESCAPED_DIFF}
@@ -391,95 +734,993 @@ RESPONSE=$(curl -s "http://%s/api/chat" \
 
 # Extract the response
 echo "$RESPONSE" | jq -r '.message.content // .error // "Error: No response from Ollama. Is it running?"'
-`, diffCmd, ollamaHost, model, escapedPrompt)
+`, diffCmd, ollamaHost, model, ollamaOptionsField(temperature), escapedPrompt)
 
-	return m.reviewerContainer(source, moduleDir).
+	return m.reviewerContainer(source, moduleDir, ollama).
 		WithExec([]string{"sh", "-c", script}).
 		Stdout(ctx)
 }
 
 // =============================================================================
-// Utility Functions
+// Structured Review Output (JSON / SARIF)
 // =============================================================================
 
-// CheckOllama verifies that Ollama is running and the model is available.
-func (m *Code) CheckOllama(
+// Finding is a single structured review comment, shaped for machine
+// consumption (CI annotations, SARIF, etc.) instead of prose.
+type Finding struct {
+	File       string `json:"file"`
+	Line       int    `json:"line"`
+	Severity   string `json:"severity"`
+	Category   string `json:"category"`
+	Message    string `json:"message"`
+	Suggestion string `json:"suggestion"`
+}
+
+// findingsSchemaPrompt instructs the model to return findings as a JSON
+// array matching the Finding struct, and nothing else.
+const findingsSchemaPrompt = `Respond with ONLY a JSON array of findings and no other text. Each element must match this shape:
+
+[
+  {
+    "file": "path/to/file",
+    "line": 123,
+    "severity": "critical" | "high" | "medium" | "low",
+    "category": "bug" | "security" | "performance" | "style" | "other",
+    "message": "what is wrong",
+    "suggestion": "how to fix it"
+  }
+]
+
+If there are no findings, respond with an empty array: []`
+
+// ReviewDiffJSON reviews a diff like ReviewDiff, but instructs the model to
+// return structured findings instead of prose. Returns the raw JSON array,
+// which unmarshals into []Finding.
+func (m *Code) ReviewDiffJSON(
 	ctx context.Context,
+	// Source directory (should be a git repository)
+	source *dagger.Directory,
+	// Base ref to compare from (e.g., "main", "origin/main")
+	base string,
+	// Head ref to compare to (e.g., "feature-branch", "HEAD")
+	head string,
+	// +optional
+	// +default="default"
+	// Review mode: default, security, performance, staged (loads from prompts/)
+	mode string,
 	// +optional
 	// +default="gemma3:4b"
 	model string,
 	// +optional
 	// +default="host.docker.internal:11434"
 	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
+) (string, error) {
+	moduleDir := dag.CurrentModule().Source()
+
+	if mode == "" {
+		mode = "default"
+	}
+	rawPrompt, fm, err := loadPrompt(moduleDir, mode, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	changedFiles, err := m.changedFilesList(ctx, source, moduleDir, base, head)
+	if err != nil {
+		return "", fmt.Errorf("failed to list changed files: %w", err)
+	}
+
+	prompt, err := renderPrompt(ctx, moduleDir, rawPrompt, promptVars{
+		Base:         base,
+		Head:         head,
+		ChangedFiles: strings.TrimSpace(changedFiles),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if model == "" {
+		model = fm.Model
+	}
+
+	return m.analyzeDiffJSON(ctx, source, moduleDir, base, head, model, ollamaHost, ollama, fm.Temperature, prompt+"\n\n"+findingsSchemaPrompt+"\n\nGit diff:\n")
+}
+
+// analyzeDiffJSON is like analyzeDiff, but asks Ollama for strict JSON
+// output (via the /api/chat "format" option) and returns the raw JSON text.
+func (m *Code) analyzeDiffJSON(
+	ctx context.Context,
+	source *dagger.Directory,
+	moduleDir *dagger.Directory,
+	base string,
+	head string,
+	model string,
+	ollamaHost string,
+	ollama *dagger.Service,
+	temperature string,
+	prompt string,
 ) (string, error) {
 	if model == "" {
 		model = defaultModel
 	}
-	if ollamaHost == "" {
-		ollamaHost = defaultOllamaHost
+	ollamaHost = resolveOllamaHost(ollama, ollamaHost)
+
+	skipPatterns, err := loadSkipPatterns(moduleDir, ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to load skip patterns: %w", err)
 	}
+	excludes := buildExcludeArgs(skipPatterns)
+	diffCmd := diffCommand(base, head, excludes)
+
+	escapedPrompt := strings.ReplaceAll(prompt, `"`, `\"`)
+	escapedPrompt = strings.ReplaceAll(escapedPrompt, "\n", `\n`)
 
 	script := fmt.Sprintf(`
 set -e
-echo "Checking Ollama at %s..."
 
-# Check if Ollama is running
-if ! curl -s "http://%s/api/tags" > /dev/null 2>&1; then
-    echo "‚ùå Cannot connect to Ollama at %s"
-    echo ""
-    echo "To fix this, run: ollama serve"
-    exit 1
+DIFF=$(%s 2>/dev/null || echo "")
+if [ -z "$DIFF" ]; then
+    echo "[]"
+    exit 0
 fi
-echo "‚úÖ Ollama is running"
 
-# Check if model is available
-MODELS=$(curl -s "http://%s/api/tags" | jq -r '.models[].name')
-if echo "$MODELS" | grep -q "^%s"; then
-    echo "‚úÖ Model '%s' is available"
-else
-    echo "‚ùå Model '%s' not found"
-    echo ""
-    echo "Available models:"
-    echo "$MODELS" | head -10
-    echo ""
-    echo "To fix this, run: ollama pull %s"
-    exit 1
+DIFF_LENGTH=${#DIFF}
+if [ $DIFF_LENGTH -gt 50000 ]; then
+    DIFF="${DIFF:0:50000}
+
+... [truncated - diff too large, showing first 50k chars] ..."
+    echo "Warning: Diff truncated from $DIFF_LENGTH to 50000 characters" >&2
 fi
 
-echo ""
-echo "üéâ Ready to review code!"
-`, ollamaHost, ollamaHost, ollamaHost, ollamaHost, model, model, model, model)
+ESCAPED_DIFF=$(echo "$DIFF" | jq -Rs .)
 
-	return dag.Container().
-		From(alpineImage).
-		WithExec([]string{"apk", "add", "--no-cache", "curl", "jq"}).
+RESPONSE=$(curl -s "http://%s/api/chat" \
+    -H "Content-Type: application/json" \
+    -d "{
+        \"model\": \"%s\",
+        \"format\": \"json\",
+        %s\"messages\": [{
+            \"role\": \"user\",
+            \"content\": \"%s\"
+        }, {
+            \"role\": \"user\",
+            \"content\": ${ESCAPED_DIFF}
+        }],
+        \"stream\": false
+    }" 2>&1)
+
+echo "$RESPONSE" | jq -r '.message.content // .error // "[]"'
+`, diffCmd, ollamaHost, model, ollamaOptionsField(temperature), escapedPrompt)
+
+	return m.reviewerContainer(source, moduleDir, ollama).
 		WithExec([]string{"sh", "-c", script}).
 		Stdout(ctx)
 }
 
-// ListModes shows available review modes (prompts).
-func (m *Code) ListModes(ctx context.Context) (string, error) {
-	moduleDir := dag.CurrentModule().Source()
-	promptsDirectory := moduleDir.Directory(promptsDir)
+// sarifLevel maps a finding's severity to a SARIF result level.
+func sarifLevel(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical", "high", "error":
+		return "error"
+	case "medium", "warning":
+		return "warning"
+	default:
+		return "note"
+	}
+}
 
-	entries, err := promptsDirectory.Entries(ctx)
-	if err != nil {
-		return "", fmt.Errorf("failed to list prompts: %w", err)
+// buildSARIF converts findings into a SARIF 2.1.0 log. failedChunks, if any,
+// become additional note-level results (rule "chunk-review-failed") instead
+// of out-of-band text, so the log stays valid SARIF even when some chunks of
+// a chunked review couldn't be reviewed.
+func buildSARIF(findings []Finding, failedChunks []string) sarifLog {
+	seenRules := map[string]bool{}
+	var rules []sarifRule
+	var results []sarifResult
+
+	for _, f := range findings {
+		ruleID := f.Category
+		if ruleID == "" {
+			ruleID = "finding"
+		}
+		if !seenRules[ruleID] {
+			seenRules[ruleID] = true
+			rules = append(rules, sarifRule{ID: ruleID, Name: ruleID})
+		}
+
+		line := f.Line
+		if line < 1 {
+			line = 1
+		}
+
+		results = append(results, sarifResult{
+			RuleID:  ruleID,
+			Level:   sarifLevel(f.Severity),
+			Message: sarifMessage{Text: f.Message},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: f.File},
+					Region:           sarifRegion{StartLine: line},
+				},
+			}},
+		})
 	}
 
-	var modes []string
-	for _, entry := range entries {
-		if strings.HasPrefix(entry, "review-") && strings.HasSuffix(entry, ".md") {
-			mode := strings.TrimPrefix(entry, "review-")
-			mode = strings.TrimSuffix(mode, ".md")
-			modes = append(modes, mode)
+	if len(failedChunks) > 0 {
+		const ruleID = "chunk-review-failed"
+		if !seenRules[ruleID] {
+			seenRules[ruleID] = true
+			rules = append(rules, sarifRule{ID: ruleID, Name: ruleID})
+		}
+		for _, f := range failedChunks {
+			path, _, _ := strings.Cut(f, ": ")
+			results = append(results, sarifResult{
+				RuleID:  ruleID,
+				Level:   "note",
+				Message: sarifMessage{Text: fmt.Sprintf("chunk review failed after retries and is not reflected in this log: %s", f)},
+				Locations: []sarifLocation{{
+					PhysicalLocation: sarifPhysicalLocation{
+						ArtifactLocation: sarifArtifactLocation{URI: path},
+						Region:           sarifRegion{StartLine: 1},
+					},
+				}},
+			})
 		}
 	}
 
-	result := "Available review modes:\n"
-	for _, mode := range modes {
+	return sarifLog{
+		Schema:  "https://raw.githubusercontent.com/oasis-tcs/sarif-spec/master/Schemata/sarif-schema-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{Driver: sarifDriver{
+				Name:  "devtools-code-review",
+				Rules: rules,
+			}},
+			Results: results,
+		}},
+	}
+}
+
+// sarifLog, sarifRun, etc. model just enough of the SARIF 2.1.0 schema to
+// carry our findings: https://docs.oasis-open.org/sarif/sarif/v2.1.0/
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// ReviewDiffSARIF reviews a diff like ReviewDiffJSON, but converts the
+// findings into a SARIF 2.1.0 log so results can be uploaded to GitHub code
+// scanning or any other SARIF viewer.
+func (m *Code) ReviewDiffSARIF(
+	ctx context.Context,
+	// Source directory (should be a git repository)
+	source *dagger.Directory,
+	// Base ref to compare from (e.g., "main", "origin/main")
+	base string,
+	// Head ref to compare to (e.g., "feature-branch", "HEAD")
+	head string,
+	// +optional
+	// +default="default"
+	// Review mode: default, security, performance, staged (loads from prompts/)
+	mode string,
+	// +optional
+	// +default="gemma3:4b"
+	model string,
+	// +optional
+	// +default="host.docker.internal:11434"
+	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
+) (string, error) {
+	raw, err := m.ReviewDiffJSON(ctx, source, base, head, mode, model, ollamaHost, ollama)
+	if err != nil {
+		return "", err
+	}
+
+	var findings []Finding
+	if err := json.Unmarshal([]byte(raw), &findings); err != nil {
+		return "", fmt.Errorf("failed to parse findings JSON: %w", err)
+	}
+
+	sarif, err := json.MarshalIndent(buildSARIF(findings, nil), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal SARIF: %w", err)
+	}
+	return string(sarif), nil
+}
+
+// =============================================================================
+// Chunked Review (map-reduce for large diffs)
+// =============================================================================
+
+const (
+	// Attempts per chunk (the first try included) before giving up on it
+	chunkMaxRetries = 3
+
+	// Base delay for exponential backoff between chunk retries
+	chunkRetryBaseDelay = 2 * time.Second
+)
+
+// fileDiff is one file's worth of a larger `git diff`, or a byte-bounded
+// slice of one if the file's diff was too big to send in one request.
+type fileDiff struct {
+	Path string
+	Body string
+}
+
+// getDiff runs `git diff` for the given ref pair and returns the raw text,
+// so ReviewDiffChunked can parse it into per-file hunks in Go rather than
+// relying on shell string slicing the way analyzeDiff's truncation does.
+func (m *Code) getDiff(ctx context.Context, source *dagger.Directory, moduleDir *dagger.Directory, base, head string) (string, error) {
+	skipPatterns, err := loadSkipPatterns(moduleDir, ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to load skip patterns: %w", err)
+	}
+	excludes := buildExcludeArgs(skipPatterns)
+	diffCmd := diffCommand(base, head, excludes)
+
+	return m.reviewerContainer(source, moduleDir, nil).
+		WithExec([]string{"sh", "-c", fmt.Sprintf("%s 2>/dev/null || true", diffCmd)}).
+		Stdout(ctx)
+}
+
+// splitDiffByFile parses `git diff` output into one fileDiff per changed file.
+func splitDiffByFile(diff string) []fileDiff {
+	var files []fileDiff
+	var current *fileDiff
+	var body strings.Builder
+
+	flush := func() {
+		if current != nil {
+			current.Body = body.String()
+			files = append(files, *current)
+		}
+		body.Reset()
+	}
+
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			flush()
+			current = &fileDiff{Path: diffGitPath(line)}
+		}
+		if current != nil {
+			body.WriteString(line)
+			body.WriteString("\n")
+		}
+	}
+	flush()
+
+	return files
+}
+
+// diffGitPath extracts the "b/..." path from a `diff --git a/x b/y` header.
+func diffGitPath(header string) string {
+	fields := strings.Fields(header)
+	if len(fields) < 4 {
+		return header
+	}
+	return strings.TrimPrefix(fields[3], "b/")
+}
+
+// splitHunks separates a file diff's preamble (the "diff --git"/"index"/
+// "---"/"+++" lines before the first hunk) from its "@@ -l,s +l,s @@" hunks,
+// each returned with its header line and trailing newline intact.
+func splitHunks(body string) (preamble string, hunks []string) {
+	lines := strings.Split(body, "\n")
+
+	i := 0
+	for i < len(lines) && !strings.HasPrefix(lines[i], "@@") {
+		i++
+	}
+	preamble = strings.Join(lines[:i], "\n")
+	if preamble != "" {
+		preamble += "\n"
+	}
+
+	var hunk []string
+	for ; i < len(lines); i++ {
+		if strings.HasPrefix(lines[i], "@@") && len(hunk) > 0 {
+			hunks = append(hunks, strings.Join(hunk, "\n")+"\n")
+			hunk = nil
+		}
+		hunk = append(hunk, lines[i])
+	}
+	if len(hunk) > 0 {
+		hunks = append(hunks, strings.Join(hunk, "\n")+"\n")
+	}
+
+	return preamble, hunks
+}
+
+// splitOversizedHunk splits one hunk (header line included) into pieces no
+// larger than budget bytes, cut on line boundaries. header is repeated at
+// the top of every piece after the first, so a chunked reviewer can still
+// recover the hunk's starting line from a continuation piece. A line longer
+// than the room left in a piece is cut mid-line, and if header alone fills
+// budget, everything after the first piece goes into one oversized piece.
+func splitOversizedHunk(header, hunk string, budget int) []string {
+	var pieces []string
+	remaining := hunk
+	first := true
+	for len(remaining) > 0 {
+		avail := budget
+		if !first {
+			avail -= len(header)
+		}
+		if avail < 1 {
+			avail = len(remaining)
+		}
+		end := avail
+		if end > len(remaining) {
+			end = len(remaining)
+		}
+		if end < len(remaining) {
+			if idx := strings.LastIndexByte(remaining[:end], '\n'); idx > 0 {
+				end = idx + 1
+			}
+		}
+		piece := remaining[:end]
+		if !first {
+			piece = header + piece
+		}
+		pieces = append(pieces, piece)
+		remaining = remaining[end:]
+		first = false
+	}
+	return pieces
+}
+
+// chunkFileDiff splits a single file's diff into pieces no larger than
+// maxBytes, cut on hunk boundaries rather than arbitrary line boundaries, so
+// every chunk keeps a valid "@@ -l,s +l,s @@" header to anchor line numbers
+// (reviewFileChunk's per-chunk findings depend on that attribution). If a
+// single hunk is itself larger than maxBytes, it's split further via
+// splitOversizedHunk, which carries the hunk's header into each piece.
+func chunkFileDiff(f fileDiff, maxBytes int) []fileDiff {
+	if maxBytes <= 0 || len(f.Body) <= maxBytes {
+		return []fileDiff{f}
+	}
+
+	preamble, hunks := splitHunks(f.Body)
+	budget := maxBytes - len(preamble)
+
+	var chunks []fileDiff
+	var buf strings.Builder
+	buf.WriteString(preamble)
+
+	flush := func() {
+		if buf.Len() > len(preamble) {
+			chunks = append(chunks, fileDiff{Path: f.Path, Body: buf.String()})
+		}
+		buf.Reset()
+		buf.WriteString(preamble)
+	}
+
+	for _, hunk := range hunks {
+		if buf.Len() > len(preamble) && buf.Len()-len(preamble)+len(hunk) > budget {
+			flush()
+		}
+		if len(hunk) <= budget {
+			buf.WriteString(hunk)
+			continue
+		}
+
+		flush()
+		header, _, _ := strings.Cut(hunk, "\n")
+		header += "\n"
+		for _, piece := range splitOversizedHunk(header, hunk, budget) {
+			chunks = append(chunks, fileDiff{Path: f.Path, Body: preamble + piece})
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+// ollamaChatScript builds the shell script that posts a two-message
+// (system prompt + body) /api/chat request to Ollama and extracts the reply,
+// optionally requesting strict JSON output.
+func ollamaChatScript(ollamaHost, model, systemPrompt string, jsonFormat bool) string {
+	escapedPrompt := strings.ReplaceAll(systemPrompt, `"`, `\"`)
+	escapedPrompt = strings.ReplaceAll(escapedPrompt, "\n", `\n`)
+
+	format := ""
+	if jsonFormat {
+		format = `\"format\": \"json\",` + "\n        "
+	}
+
+	return fmt.Sprintf(`
+set -e
+ESCAPED_BODY=$(jq -Rs . < /tmp/chunk.txt)
+
+RESPONSE=$(curl -s "http://%s/api/chat" \
+    -H "Content-Type: application/json" \
+    -d "{
+        \"model\": \"%s\",
+        %s\"messages\": [{
+            \"role\": \"user\",
+            \"content\": \"%s\"
+        }, {
+            \"role\": \"user\",
+            \"content\": ${ESCAPED_BODY}
+        }],
+        \"stream\": false
+    }" 2>&1)
+
+echo "$RESPONSE" | jq -r '.message.content // .error // "Error: No response from Ollama. Is it running?"'
+`, ollamaHost, model, format, escapedPrompt)
+}
+
+// callOllama posts body to Ollama under systemPrompt and returns the reply
+// text, optionally requesting strict JSON output via jsonFormat. Unlike
+// analyzeDiff/analyzeDiffJSON, body comes from Go rather than a git command
+// run inside the container, so it's mounted as a file instead of inlined.
+func (m *Code) callOllama(ctx context.Context, model, ollamaHost string, ollama *dagger.Service, systemPrompt, body string, jsonFormat bool) (string, error) {
+	if model == "" {
+		model = defaultModel
+	}
+	ollamaHost = resolveOllamaHost(ollama, ollamaHost)
+
+	ctr := withOllama(dag.Container().
+		From(alpineImage).
+		WithExec([]string{"apk", "add", "--no-cache", "curl", "jq"}), ollama).
+		WithNewFile("/tmp/chunk.txt", body).
+		WithWorkdir("/tmp")
+
+	return ctr.
+		WithExec([]string{"sh", "-c", ollamaChatScript(ollamaHost, model, systemPrompt, jsonFormat)}).
+		Stdout(ctx)
+}
+
+// reviewFileChunk reviews one chunk, retrying with exponential backoff on
+// transient Ollama errors, and stamps its findings with the chunk's file
+// path so attribution survives into the reduce pass (and SARIF export).
+func (m *Code) reviewFileChunk(ctx context.Context, model, ollamaHost string, ollama *dagger.Service, chunk fileDiff) ([]Finding, error) {
+	systemPrompt := fmt.Sprintf("%s\n\nReview only the following diff for %s:\n", findingsSchemaPrompt, chunk.Path)
+
+	var lastErr error
+	for attempt := 0; attempt < chunkMaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(chunkRetryBaseDelay * time.Duration(1<<uint(attempt-1))):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		raw, err := m.callOllama(ctx, model, ollamaHost, ollama, systemPrompt, chunk.Body, true)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var findings []Finding
+		if err := json.Unmarshal([]byte(raw), &findings); err != nil {
+			lastErr = fmt.Errorf("invalid JSON from model for %s: %w", chunk.Path, err)
+			continue
+		}
+
+		for i := range findings {
+			if findings[i].File == "" {
+				findings[i].File = chunk.Path
+			}
+		}
+		return findings, nil
+	}
+
+	return nil, fmt.Errorf("review of %s failed after %d attempts: %w", chunk.Path, chunkMaxRetries, lastErr)
+}
+
+// reviewChunksConcurrently reviews every chunk, running up to maxConcurrency
+// of them at a time via Dagger's container-level parallelism. It always
+// returns the findings gathered from chunks that succeeded, along with a
+// description of any chunks that failed after retries, so one bad chunk
+// never drops the rest of the review.
+func (m *Code) reviewChunksConcurrently(ctx context.Context, model, ollamaHost string, ollama *dagger.Service, chunks []fileDiff, maxConcurrency int) ([]Finding, []string) {
+	sem := make(chan struct{}, maxConcurrency)
+	results := make([][]Finding, len(chunks))
+	errs := make([]error, len(chunks))
+
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, chunk fileDiff) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			findings, err := m.reviewFileChunk(ctx, model, ollamaHost, ollama, chunk)
+			results[i] = findings
+			errs[i] = err
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	var all []Finding
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", chunks[i].Path, err))
+			continue
+		}
+		all = append(all, results[i]...)
+	}
+
+	return all, failed
+}
+
+// reduceFindings merges the per-chunk findings into one prioritized report
+// via a final LLM pass, collapsing duplicate or overlapping findings that
+// independent chunk reviews surfaced separately.
+func (m *Code) reduceFindings(ctx context.Context, model, ollamaHost string, ollama *dagger.Service, findings []Finding) (string, error) {
+	if len(findings) == 0 {
+		return "No findings.", nil
+	}
+
+	raw, err := json.Marshal(findings)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal findings for reduce pass: %w", err)
+	}
+
+	prompt := `You are merging code review findings gathered independently per file into a
+single report for a human reviewer.
+
+Below is a JSON array of findings, each with {file, line, severity, category,
+message, suggestion}. Merge duplicate or overlapping findings, drop anything
+trivial, and produce a prioritized Markdown report: most severe first,
+grouped by file, with a one-line summary at the top.
+
+Findings:
+`
+
+	return m.callOllama(ctx, model, ollamaHost, ollama, prompt, string(raw), false)
+}
+
+// reduceFindingsJSON is like reduceFindings, but asks Ollama to merge the
+// per-chunk findings into a deduplicated []Finding instead of prose, so
+// file/line attribution survives the reduce pass for callers (like
+// ReviewDiffChunkedSARIF) that need structured output.
+func (m *Code) reduceFindingsJSON(ctx context.Context, model, ollamaHost string, ollama *dagger.Service, findings []Finding) ([]Finding, error) {
+	if len(findings) == 0 {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(findings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal findings for reduce pass: %w", err)
+	}
+
+	prompt := findingsSchemaPrompt + `
+
+Merge the duplicate or overlapping findings below (gathered independently per
+file) into one deduplicated JSON array, preserving each finding's original
+"file" and "line". Drop anything trivial.
+
+Findings:
+`
+
+	merged, err := m.callOllama(ctx, model, ollamaHost, ollama, prompt, string(raw), true)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Finding
+	if err := json.Unmarshal([]byte(merged), &result); err != nil {
+		return nil, fmt.Errorf("invalid JSON from model for reduce pass: %w", err)
+	}
+	return result, nil
+}
+
+// chunkFailuresWarning renders a Markdown warning listing chunks that failed
+// review after retries, so partial results don't silently pass as complete.
+func chunkFailuresWarning(failed []string) string {
+	if len(failed) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\n---\n\n**Warning: %d chunk(s) failed review after retries and are not reflected above:**\n\n- %s\n",
+		len(failed), strings.Join(failed, "\n- "))
+}
+
+// ReviewDiffChunked reviews a large diff by splitting it into per-file (and,
+// for oversized files, per-chunk) pieces, reviewing each concurrently, then
+// merging the results with a final reduce pass. Unlike ReviewDiff, it never
+// silently truncates: every hunk gets reviewed, just not all in one request.
+func (m *Code) ReviewDiffChunked(
+	ctx context.Context,
+	// Source directory (should be a git repository)
+	source *dagger.Directory,
+	// Base ref to compare from (e.g., "main", "origin/main")
+	base string,
+	// Head ref to compare to (e.g., "feature-branch", "HEAD")
+	head string,
+	// +optional
+	// +default="gemma3:4b"
+	model string,
+	// +optional
+	// +default="host.docker.internal:11434"
+	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
+	// +optional
+	// +default=4
+	// Maximum number of chunks reviewed concurrently
+	maxConcurrency int,
+	// +optional
+	// +default=8000
+	// Maximum diff bytes sent to the model in a single request
+	maxBytesPerChunk int,
+) (string, error) {
+	moduleDir := dag.CurrentModule().Source()
+
+	diff, err := m.getDiff(ctx, source, moduleDir, base, head)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute diff: %w", err)
+	}
+	if strings.TrimSpace(diff) == "" {
+		return "No changes found between the specified refs.", nil
+	}
+
+	if maxConcurrency <= 0 {
+		maxConcurrency = 4
+	}
+	if maxBytesPerChunk <= 0 {
+		maxBytesPerChunk = 8000
+	}
+
+	var chunks []fileDiff
+	for _, f := range splitDiffByFile(diff) {
+		chunks = append(chunks, chunkFileDiff(f, maxBytesPerChunk)...)
+	}
+
+	// reviewChunksConcurrently returns whatever findings it gathered even when
+	// some chunks failed after retries, so a single flaky chunk degrades the
+	// report to a warning rather than losing every other chunk's findings.
+	findings, failed := m.reviewChunksConcurrently(ctx, model, ollamaHost, ollama, chunks, maxConcurrency)
+
+	report, err := m.reduceFindings(ctx, model, ollamaHost, ollama, findings)
+	if err != nil {
+		return "", err
+	}
+	return report + chunkFailuresWarning(failed), nil
+}
+
+// ReviewDiffChunkedSARIF reviews a large diff like ReviewDiffChunked, but
+// merges the per-chunk findings through reduceFindingsJSON instead of a
+// prose reduce pass, so file/line attribution survives into a SARIF 2.1.0
+// log. Chunks that fail after retries are recorded as note-level results
+// (rule "chunk-review-failed") inside the log itself, so the output stays
+// valid SARIF even on partial failure.
+func (m *Code) ReviewDiffChunkedSARIF(
+	ctx context.Context,
+	// Source directory (should be a git repository)
+	source *dagger.Directory,
+	// Base ref to compare from (e.g., "main", "origin/main")
+	base string,
+	// Head ref to compare to (e.g., "feature-branch", "HEAD")
+	head string,
+	// +optional
+	// +default="gemma3:4b"
+	model string,
+	// +optional
+	// +default="host.docker.internal:11434"
+	ollamaHost string,
+	// +optional
+	// Ollama service to review against (see Code.ServeOllama). When set, this
+	// runs hermetically and ollamaHost is ignored.
+	ollama *dagger.Service,
+	// +optional
+	// +default=4
+	// Maximum number of chunks reviewed concurrently
+	maxConcurrency int,
+	// +optional
+	// +default=8000
+	// Maximum diff bytes sent to the model in a single request
+	maxBytesPerChunk int,
+) (string, error) {
+	moduleDir := dag.CurrentModule().Source()
+
+	diff, err := m.getDiff(ctx, source, moduleDir, base, head)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute diff: %w", err)
+	}
+	if strings.TrimSpace(diff) == "" {
+		sarif, err := json.MarshalIndent(buildSARIF(nil, nil), "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal SARIF: %w", err)
+		}
+		return string(sarif), nil
+	}
+
+	if maxConcurrency <= 0 {
+		maxConcurrency = 4
+	}
+	if maxBytesPerChunk <= 0 {
+		maxBytesPerChunk = 8000
+	}
+
+	var chunks []fileDiff
+	for _, f := range splitDiffByFile(diff) {
+		chunks = append(chunks, chunkFileDiff(f, maxBytesPerChunk)...)
+	}
+
+	findings, failed := m.reviewChunksConcurrently(ctx, model, ollamaHost, ollama, chunks, maxConcurrency)
+
+	merged, err := m.reduceFindingsJSON(ctx, model, ollamaHost, ollama, findings)
+	if err != nil {
+		return "", err
+	}
+
+	sarif, err := json.MarshalIndent(buildSARIF(merged, failed), "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal SARIF: %w", err)
+	}
+	return string(sarif), nil
+}
+
+// =============================================================================
+// Utility Functions
+// =============================================================================
+
+// CheckOllama verifies that Ollama is running and the model is available.
+func (m *Code) CheckOllama(
+	ctx context.Context,
+	// +optional
+	// +default="gemma3:4b"
+	model string,
+	// +optional
+	// +default="host.docker.internal:11434"
+	ollamaHost string,
+) (string, error) {
+	if model == "" {
+		model = defaultModel
+	}
+	if ollamaHost == "" {
+		ollamaHost = defaultOllamaHost
+	}
+
+	script := fmt.Sprintf(`
+set -e
+echo "Checking Ollama at %s..."
+
+# Check if Ollama is running
+if ! curl -s "http://%s/api/tags" > /dev/null 2>&1; then
+    echo "‚ùå Cannot connect to Ollama at %s"
+    echo ""
+    echo "To fix this, run: ollama serve"
+    exit 1
+fi
+echo "‚úÖ Ollama is running"
+
+# Check if model is available
+MODELS=$(curl -s "http://%s/api/tags" | jq -r '.models[].name')
+if echo "$MODELS" | grep -q "^%s"; then
+    echo "‚úÖ Model '%s' is available"
+else
+    echo "‚ùå Model '%s' not found"
+    echo ""
+    echo "Available models:"
+    echo "$MODELS" | head -10
+    echo ""
+    echo "To fix this, run: ollama pull %s"
+    exit 1
+fi
+
+echo ""
+echo "üéâ Ready to review code!"
+`, ollamaHost, ollamaHost, ollamaHost, ollamaHost, model, model, model, model)
+
+	return dag.Container().
+		From(alpineImage).
+		WithExec([]string{"apk", "add", "--no-cache", "curl", "jq"}).
+		WithExec([]string{"sh", "-c", script}).
+		Stdout(ctx)
+}
+
+// ListModes shows available review modes (prompts).
+func (m *Code) ListModes(ctx context.Context) (string, error) {
+	moduleDir := dag.CurrentModule().Source()
+	promptsDirectory := moduleDir.Directory(promptsDir)
+
+	entries, err := promptsDirectory.Entries(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list prompts: %w", err)
+	}
+
+	var modes []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, "review-") && strings.HasSuffix(entry, ".md") {
+			mode := strings.TrimPrefix(entry, "review-")
+			mode = strings.TrimSuffix(mode, ".md")
+			modes = append(modes, mode)
+		}
+	}
+
+	result := "Available review modes:\n"
+	for _, mode := range modes {
 		result += fmt.Sprintf("  - %s\n", mode)
 	}
 	result += "\nUsage: --mode=<mode>"
 	return result, nil
 }
+
+// RenderPrompt renders prompts/review-<mode>.md with the given variables,
+// without running a review - useful for debugging a prompt template.
+// Recognized vars: repo, base, head, changedFiles, language.
+func (m *Code) RenderPrompt(
+	ctx context.Context,
+	// Review mode to render (loads prompts/review-<mode>.md)
+	mode string,
+	// +optional
+	// Template variables: repo, base, head, changedFiles, language
+	vars map[string]string,
+) (string, error) {
+	moduleDir := dag.CurrentModule().Source()
+
+	rawPrompt, _, err := loadPrompt(moduleDir, mode, ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return renderPrompt(ctx, moduleDir, rawPrompt, promptVars{
+		Repo:         vars["repo"],
+		Base:         vars["base"],
+		Head:         vars["head"],
+		ChangedFiles: vars["changedFiles"],
+		Language:     vars["language"],
+	})
+}
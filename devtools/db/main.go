@@ -4,14 +4,36 @@
 //
 //	dagger -m ./devtools/db call serve-db up
 //	dagger -m ./devtools/db call serve-db --database=myapp up
+//
+//	# Serve with schema loaded and extensions installed
+//	dagger -m ./devtools/db call serve-db \
+//	  --init-scripts=./db/init --extensions=pgcrypto,pg_trgm up
+//
+//	# Run migrations against a running service
+//	dagger -m ./devtools/db call migrate --service=tcp://localhost:5432 \
+//	  --migrations-dir=./db/migrations --tool=sqlx
+//
+//	# Load a dev dataset
+//	dagger -m ./devtools/db call seed-from-dump --service=tcp://localhost:5432 \
+//	  --dump-file=./db/seed.dump
 
 package main
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
 	"dagger/db/internal/dagger"
 )
 
-const postgresImage = "postgres:18-alpine"
+const (
+	postgresImage = "postgres:18-alpine"
+	rustSlimImage = "rust:1.85-slim"
+	golangImage   = "golang:1.23-alpine"
+	alpineImage   = "alpine:3.19"
+)
 
 type Db struct{}
 
@@ -24,12 +46,125 @@ func (m *Db) ServeDb(
 	// +optional
 	// +default="postgres"
 	database string,
+	// +optional
+	// +default="postgres"
+	user string,
+	// +optional
+	// +default="postgres"
+	password string,
+	// +optional
+	// SQL/shell scripts run once on first startup, mounted at
+	// /docker-entrypoint-initdb.d (Postgres runs them in filename order)
+	initScripts *dagger.Directory,
+	// +optional
+	// Extensions to CREATE EXTENSION on startup, e.g. []string{"pgcrypto", "postgis"}
+	extensions []string,
 ) *dagger.Service {
-	return dag.Container().
+	ctr := dag.Container().
 		From(postgresImage).
-		WithEnvVariable("POSTGRES_PASSWORD", "postgres").
-		WithEnvVariable("POSTGRES_USER", "postgres").
-		WithEnvVariable("POSTGRES_DB", database).
+		WithEnvVariable("POSTGRES_PASSWORD", password).
+		WithEnvVariable("POSTGRES_USER", user).
+		WithEnvVariable("POSTGRES_DB", database)
+
+	if initScripts != nil {
+		ctr = ctr.WithDirectory("/docker-entrypoint-initdb.d", initScripts)
+	}
+	if len(extensions) > 0 {
+		ctr = ctr.WithNewFile("/docker-entrypoint-initdb.d/00-extensions.sql", extensionsSQL(extensions))
+	}
+
+	return ctr.
 		WithExposedPort(port).
 		AsService()
 }
+
+// extensionsSQL renders one CREATE EXTENSION IF NOT EXISTS statement per
+// extension, to run via Postgres' docker-entrypoint-initdb.d on first startup.
+func extensionsSQL(extensions []string) string {
+	var sql strings.Builder
+	for _, ext := range extensions {
+		sql.WriteString(fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s;\n", ext))
+	}
+	return sql.String()
+}
+
+// withMigrationSource mounts migrationsDir and binds service as "db", so
+// each tool's container only needs to add its own image and CLI.
+func withMigrationSource(ctr *dagger.Container, service *dagger.Service, migrationsDir *dagger.Directory, databaseURL string) *dagger.Container {
+	return ctr.
+		WithServiceBinding("db", service).
+		WithDirectory("/migrations", migrationsDir).
+		WithWorkdir("/migrations").
+		WithEnvVariable("DATABASE_URL", databaseURL)
+}
+
+// Migrate runs the migrations in migrationsDir against a running Postgres
+// service. Bind the service to callers (e.g. Rust.ServeApi) via
+// WithServiceBinding so migrations and the app under test share one database.
+func (m *Db) Migrate(
+	ctx context.Context,
+	// Running Postgres service to migrate (e.g. from Db.ServeDb)
+	service *dagger.Service,
+	// Directory containing the migration files
+	migrationsDir *dagger.Directory,
+	// +optional
+	// +default="sqlx"
+	// Migration tool: "sqlx", "golang-migrate", or "atlas"
+	tool string,
+	// +optional
+	// +default="postgres://postgres:postgres@db:5432/postgres"
+	databaseURL string,
+) (string, error) {
+	switch tool {
+	case "sqlx":
+		return withMigrationSource(dag.Container().From(rustSlimImage), service, migrationsDir, databaseURL).
+			WithExec([]string{"cargo", "install", "sqlx-cli", "--no-default-features", "--features", "postgres"}).
+			WithExec([]string{"sqlx", "migrate", "run", "--source", "/migrations"}).
+			Stdout(ctx)
+	case "golang-migrate":
+		return withMigrationSource(dag.Container().From(golangImage), service, migrationsDir, databaseURL).
+			WithExec([]string{"go", "install", "-tags", "postgres", "github.com/golang-migrate/migrate/v4/cmd/migrate@latest"}).
+			WithExec([]string{"sh", "-c", `migrate -path /migrations -database "$DATABASE_URL" up`}).
+			Stdout(ctx)
+	case "atlas":
+		return withMigrationSource(dag.Container().From(alpineImage), service, migrationsDir, databaseURL).
+			WithExec([]string{"sh", "-c", "apk add --no-cache curl && curl -sSf https://atlasgo.sh | sh"}).
+			WithExec([]string{"sh", "-c", `atlas migrate apply --dir file:///migrations --url "$DATABASE_URL"`}).
+			Stdout(ctx)
+	default:
+		return "", fmt.Errorf("unsupported migration tool %q (want sqlx, golang-migrate, or atlas)", tool)
+	}
+}
+
+// SeedFromDump loads a dev dataset dump into a running Postgres service via
+// pg_restore for custom-format dumps (.dump/.pgdump) or psql for plain SQL
+// dumps (.sql), picked by the dump file's extension.
+func (m *Db) SeedFromDump(
+	ctx context.Context,
+	// Running Postgres service to seed (e.g. from Db.ServeDb)
+	service *dagger.Service,
+	// Dump file to load
+	dumpFile *dagger.File,
+	// +optional
+	// +default="postgres://postgres:postgres@db:5432/postgres"
+	databaseURL string,
+) (string, error) {
+	name, err := dumpFile.Name(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read dump file name: %w", err)
+	}
+	dumpPath := filepath.Join("/seed", name)
+
+	restoreCmd := fmt.Sprintf(`psql "$DATABASE_URL" -f %s`, dumpPath)
+	if strings.HasSuffix(name, ".dump") || strings.HasSuffix(name, ".pgdump") {
+		restoreCmd = fmt.Sprintf(`pg_restore --no-owner --clean --if-exists -d "$DATABASE_URL" %s`, dumpPath)
+	}
+
+	return dag.Container().
+		From(postgresImage).
+		WithServiceBinding("db", service).
+		WithFile(dumpPath, dumpFile).
+		WithEnvVariable("DATABASE_URL", databaseURL).
+		WithExec([]string{"sh", "-c", restoreCmd}).
+		Stdout(ctx)
+}
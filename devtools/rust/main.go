@@ -11,11 +11,21 @@
 //	dagger -m ./devtools/rust call test-workspace --source=./applications/thiccc
 //	dagger -m ./devtools/rust call check-format --source=./applications/thiccc
 //	dagger -m ./devtools/rust call run-clippy --source=./applications/thiccc
+//
+//	# Speed up repeated checks with sccache on top of the cargo cache mounts
+//	dagger -m ./devtools/rust call check-workspace --source=./applications/thiccc --use-sccache
+//
+//	# Cross-compile a release binary for multiple targets at once
+//	dagger -m ./devtools/rust call build-cross --source=./applications/thiccc/api_server \
+//	  --targets=x86_64-unknown-linux-gnu,aarch64-unknown-linux-gnu --release \
+//	  export --path=./dist
 
 package main
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"dagger/rust/internal/dagger"
 )
@@ -24,12 +34,43 @@ const rustImage = "rust:1.85-slim"
 
 type Rust struct{}
 
-// baseContainer creates a container with the Rust toolchain and source mounted.
-func (m *Rust) baseContainer(source *dagger.Directory) *dagger.Container {
-	return dag.Container().
+// cacheSuffix appends a user-provided cache key to a cache volume name, so
+// callers can keep multiple workspaces (or cache generations) from sharing
+// one cache volume.
+func cacheSuffix(cacheKey string) string {
+	if cacheKey == "" {
+		return ""
+	}
+	return "-" + cacheKey
+}
+
+// baseContainer creates a container with the Rust toolchain and source
+// mounted, with cargo's registry/git caches and the target dir backed by
+// cache volumes so repeated checks/builds don't redownload or recompile
+// dependencies. Set useSccache to additionally cache compiled artifacts
+// across crates via sccache.
+func (m *Rust) baseContainer(
+	source *dagger.Directory,
+	cacheKey string,
+	useSccache bool,
+) *dagger.Container {
+	ctr := dag.Container().
 		From(rustImage).
+		WithMountedCache("/usr/local/cargo/registry", dag.CacheVolume("cargo-registry"+cacheSuffix(cacheKey))).
+		WithMountedCache("/usr/local/cargo/git", dag.CacheVolume("cargo-git"+cacheSuffix(cacheKey))).
 		WithDirectory("/src", source).
-		WithWorkdir("/src")
+		WithWorkdir("/src").
+		WithMountedCache("/src/target", dag.CacheVolume("cargo-target"+cacheSuffix(cacheKey)))
+
+	if useSccache {
+		ctr = ctr.
+			WithExec([]string{"cargo", "install", "sccache", "--locked"}).
+			WithMountedCache("/root/.cache/sccache", dag.CacheVolume("sccache"+cacheSuffix(cacheKey))).
+			WithEnvVariable("RUSTC_WRAPPER", "sccache").
+			WithEnvVariable("SCCACHE_DIR", "/root/.cache/sccache")
+	}
+
+	return ctr
 }
 
 // BuildBinary compiles a Rust project and returns the container with build artifacts.
@@ -39,12 +80,19 @@ func (m *Rust) BuildBinary(
 	// +optional
 	// +default=false
 	release bool,
+	// +optional
+	// Cache volume suffix, so unrelated workspaces don't share one cache
+	cacheKey string,
+	// +optional
+	// +default=false
+	// Wrap rustc with sccache for cross-crate compile caching
+	useSccache bool,
 ) *dagger.Container {
 	args := []string{"cargo", "build"}
 	if release {
 		args = append(args, "--release")
 	}
-	return m.baseContainer(source).WithExec(args)
+	return m.baseContainer(source, cacheKey, useSccache).WithExec(args)
 }
 
 // ServeApi builds and runs a Rust API server as a background service.
@@ -57,13 +105,20 @@ func (m *Rust) ServeApi(
 	// +optional
 	// +default=false
 	release bool,
+	// +optional
+	// Cache volume suffix, so unrelated workspaces don't share one cache
+	cacheKey string,
+	// +optional
+	// +default=false
+	// Wrap rustc with sccache for cross-crate compile caching
+	useSccache bool,
 ) *dagger.Service {
 	args := []string{"cargo", "run"}
 	if release {
 		args = append(args, "--release")
 	}
 
-	return m.BuildBinary(source, release).
+	return m.BuildBinary(source, release, cacheKey, useSccache).
 		WithExec(args).
 		WithExposedPort(port).
 		AsService()
@@ -75,8 +130,15 @@ func (m *Rust) CheckWorkspace(
 	ctx context.Context,
 	// Path to workspace root containing Cargo.toml with [workspace]
 	source *dagger.Directory,
+	// +optional
+	// Cache volume suffix, so unrelated workspaces don't share one cache
+	cacheKey string,
+	// +optional
+	// +default=false
+	// Wrap rustc with sccache for cross-crate compile caching
+	useSccache bool,
 ) (string, error) {
-	return m.baseContainer(source).
+	return m.baseContainer(source, cacheKey, useSccache).
 		WithExec([]string{"sh", "-c", "cargo check --workspace 2>&1"}).
 		Stdout(ctx)
 }
@@ -87,8 +149,15 @@ func (m *Rust) TestWorkspace(
 	ctx context.Context,
 	// Path to workspace root containing Cargo.toml with [workspace]
 	source *dagger.Directory,
+	// +optional
+	// Cache volume suffix, so unrelated workspaces don't share one cache
+	cacheKey string,
+	// +optional
+	// +default=false
+	// Wrap rustc with sccache for cross-crate compile caching
+	useSccache bool,
 ) (string, error) {
-	return m.baseContainer(source).
+	return m.baseContainer(source, cacheKey, useSccache).
 		WithExec([]string{"sh", "-c", "cargo test --workspace 2>&1"}).
 		Stdout(ctx)
 }
@@ -99,8 +168,11 @@ func (m *Rust) CheckFormat(
 	ctx context.Context,
 	// Path to workspace root containing Cargo.toml
 	source *dagger.Directory,
+	// +optional
+	// Cache volume suffix, so unrelated workspaces don't share one cache
+	cacheKey string,
 ) (string, error) {
-	return m.baseContainer(source).
+	return m.baseContainer(source, cacheKey, false).
 		WithExec([]string{"sh", "-c", "cargo fmt --check 2>&1"}).
 		Stdout(ctx)
 }
@@ -111,8 +183,118 @@ func (m *Rust) RunClippy(
 	ctx context.Context,
 	// Path to workspace root containing Cargo.toml with [workspace]
 	source *dagger.Directory,
+	// +optional
+	// Cache volume suffix, so unrelated workspaces don't share one cache
+	cacheKey string,
+	// +optional
+	// +default=false
+	// Wrap rustc with sccache for cross-crate compile caching
+	useSccache bool,
 ) (string, error) {
-	return m.baseContainer(source).
+	return m.baseContainer(source, cacheKey, useSccache).
 		WithExec([]string{"sh", "-c", "cargo clippy --workspace -- -D warnings 2>&1"}).
 		Stdout(ctx)
 }
+
+// =============================================================================
+// Cross-Compilation
+// =============================================================================
+
+// crossTarget describes how to set up the container to link for a given
+// Rust target triple from this x86_64 host.
+type crossTarget struct {
+	// apt packages providing the linker/sysroot for this target
+	aptPackages []string
+	// linker binary to set via CARGO_TARGET_<TRIPLE>_LINKER
+	linker string
+}
+
+// knownCrossTargets is the supported BuildCross target matrix.
+var knownCrossTargets = map[string]crossTarget{
+	"x86_64-unknown-linux-gnu": {},
+	"aarch64-unknown-linux-gnu": {
+		aptPackages: []string{"gcc-aarch64-linux-gnu"},
+		linker:      "aarch64-linux-gnu-gcc",
+	},
+	"x86_64-unknown-linux-musl": {
+		aptPackages: []string{"musl-tools"},
+		linker:      "musl-gcc",
+	},
+	// aarch64-unknown-linux-musl is intentionally not listed: apt only ships
+	// musl-tools for the host (x86_64) architecture and gcc-aarch64-linux-gnu
+	// is a glibc cross-linker, so there's no real aarch64 musl cross-gcc
+	// available here. Add it once a musl-cross-make (or equivalent) toolchain
+	// is wired up.
+	"x86_64-pc-windows-gnu": {
+		aptPackages: []string{"mingw-w64"},
+		linker:      "x86_64-w64-mingw32-gcc",
+	},
+}
+
+// linkerEnvVar converts a target triple into the CARGO_TARGET_<TRIPLE>_LINKER
+// env var cargo reads, e.g. aarch64-unknown-linux-gnu ->
+// CARGO_TARGET_AARCH64_UNKNOWN_LINUX_GNU_LINKER.
+func linkerEnvVar(triple string) string {
+	return "CARGO_TARGET_" + strings.ToUpper(strings.ReplaceAll(triple, "-", "_")) + "_LINKER"
+}
+
+// buildCrossTarget builds source for a single target triple and returns the
+// resulting target/<triple>/<profile> directory.
+func (m *Rust) buildCrossTarget(source *dagger.Directory, triple string, target crossTarget, release bool) *dagger.Directory {
+	ctr := dag.Container().
+		From(rustImage).
+		WithDirectory("/src", source).
+		WithWorkdir("/src")
+
+	if len(target.aptPackages) > 0 {
+		ctr = ctr.WithExec([]string{"sh", "-c", fmt.Sprintf(
+			"apt-get update && apt-get install -y --no-install-recommends %s", strings.Join(target.aptPackages, " "),
+		)})
+	}
+
+	ctr = ctr.WithExec([]string{"rustup", "target", "add", triple})
+	if target.linker != "" {
+		ctr = ctr.WithEnvVariable(linkerEnvVar(triple), target.linker)
+	}
+
+	args := []string{"cargo", "build", "--target", triple}
+	profile := "debug"
+	if release {
+		args = append(args, "--release")
+		profile = "release"
+	}
+
+	return ctr.WithExec(args).Directory(fmt.Sprintf("/src/target/%s/%s", triple, profile))
+}
+
+// BuildCross cross-compiles a crate for each of the given target triples and
+// returns a directory with one subdirectory per triple containing that
+// target's build output. Builds run as independent Dagger pipelines, so the
+// engine executes them in parallel.
+//
+// Supported targets: x86_64-unknown-linux-gnu, aarch64-unknown-linux-gnu,
+// x86_64-unknown-linux-musl, x86_64-pc-windows-gnu.
+func (m *Rust) BuildCross(
+	// Path to the Rust project directory containing Cargo.toml
+	source *dagger.Directory,
+	// Target triples to build for
+	targets []string,
+	// +optional
+	// +default=false
+	release bool,
+) (*dagger.Directory, error) {
+	if len(targets) == 0 {
+		targets = []string{"x86_64-unknown-linux-gnu"}
+	}
+
+	out := dag.Directory()
+	for _, triple := range targets {
+		target, ok := knownCrossTargets[triple]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cross-compilation target %q", triple)
+		}
+		out = out.WithDirectory(triple, m.buildCrossTarget(source, triple, target, release))
+	}
+
+	return out, nil
+}